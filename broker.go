@@ -0,0 +1,65 @@
+package main
+
+import "sync"
+
+// frameBufferSize is how many frames a subscriber may lag behind before the
+// broker starts dropping its oldest buffered frame to make room for the newest.
+const frameBufferSize = 32
+
+// frameBroker fans a stream of parsed telemetry frames out to any number of
+// subscribers (TCP clients, WebSocket clients, etc) without letting a slow
+// consumer block or slow down the UDP read loop.
+type frameBroker struct {
+	mu   sync.Mutex
+	subs map[chan []byte]struct{}
+}
+
+// newFrameBroker creates an empty broker ready to accept subscribers.
+func newFrameBroker() *frameBroker {
+	return &frameBroker{subs: make(map[chan []byte]struct{})}
+}
+
+// Subscribe registers a new buffered channel that will receive every frame
+// published after this call. Callers must pass the returned channel to
+// Unsubscribe once they're done reading from it.
+func (b *frameBroker) Subscribe() chan []byte {
+	ch := make(chan []byte, frameBufferSize)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe removes and closes a channel previously returned by Subscribe.
+func (b *frameBroker) Unsubscribe(ch chan []byte) {
+	b.mu.Lock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+	b.mu.Unlock()
+}
+
+// Publish sends frame to every current subscriber. If a subscriber's buffer
+// is full (a slow consumer), its oldest queued frame is dropped to make room
+// rather than blocking the publisher.
+func (b *frameBroker) Publish(frame []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- frame:
+		default:
+			// Slow consumer: drop the oldest frame and try once more.
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- frame:
+			default:
+			}
+		}
+	}
+}
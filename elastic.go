@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// elasticBatchSize and elasticBatchInterval bound how long a batch of
+// telemetry documents is held before being flushed, whichever comes first.
+const (
+	elasticBatchSize     = 100
+	elasticBatchInterval = 500 * time.Millisecond
+	elasticIndexName     = "forza-telemetry"
+)
+
+// elasticDoc is a single telemetry document as sent to Elasticsearch. The
+// per-type maps are flattened into one top-level object so Kibana can chart
+// any field directly.
+type elasticDoc struct {
+	Timestamp string                 `json:"@timestamp"`
+	Session   string                 `json:"session_id"`
+	Game      string                 `json:"game"`
+	Fields    map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON flattens Fields alongside the fixed top-level keys.
+func (d elasticDoc) MarshalJSON() ([]byte, error) {
+	out := make(map[string]interface{}, len(d.Fields)+3)
+	for k, v := range d.Fields {
+		out[k] = v
+	}
+	out["@timestamp"] = d.Timestamp
+	out["session_id"] = d.Session
+	out["game"] = d.Game
+	return json.Marshal(out)
+}
+
+// elasticSink batches telemetry frames and forwards them to Elasticsearch's
+// _bulk API, dropping the oldest queued document (not a whole batch) under
+// backpressure rather than blocking the UDP read loop.
+type elasticSink struct {
+	url       string
+	sessionID string
+	client    *http.Client
+
+	docs  chan elasticDoc
+	batch []elasticDoc
+
+	// dropped and sent are touched from both the caller goroutine (Submit,
+	// called straight off the UDP/replay path) and the background run/flush
+	// goroutine, so they're updated atomically rather than guarded by a mutex.
+	dropped int64
+	sent    int64
+}
+
+// newElasticSink creates a sink targeting the given Elasticsearch host/port
+// and starts its background batching loop.
+func newElasticSink(ip string, port int) *elasticSink {
+	s := &elasticSink{
+		url:       fmt.Sprintf("http://%s:%d", ip, port),
+		sessionID: uuid.New().String(),
+		client:    &http.Client{Timeout: 5 * time.Second},
+		docs:      make(chan elasticDoc, elasticBatchSize*4),
+	}
+
+	if err := s.ensureIndexTemplate(); err != nil {
+		log.Println("Error creating Elasticsearch index template:", err)
+	}
+
+	go s.run()
+	return s
+}
+
+// Submit queues a flattened telemetry frame for forwarding. If the internal
+// queue is full (Elasticsearch can't keep up), the oldest queued document is
+// dropped to make room and a counter is incremented. game is "FM7", "FH4",
+// or "FH5", as detected from the packet's format.
+func (s *elasticSink) Submit(game string, fields map[string]interface{}) {
+	doc := elasticDoc{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Session:   s.sessionID,
+		Game:      game,
+		Fields:    fields,
+	}
+
+	select {
+	case s.docs <- doc:
+	default:
+		select {
+		case <-s.docs:
+			atomic.AddInt64(&s.dropped, 1)
+		default:
+		}
+		select {
+		case s.docs <- doc:
+		default:
+		}
+	}
+}
+
+// run batches incoming documents and flushes every elasticBatchSize
+// documents or elasticBatchInterval, whichever comes first.
+func (s *elasticSink) run() {
+	ticker := time.NewTicker(elasticBatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case doc := <-s.docs:
+			s.batch = append(s.batch, doc)
+			if len(s.batch) >= elasticBatchSize {
+				s.flush()
+			}
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+}
+
+// flush POSTs the current batch to the _bulk API and clears it. In debug
+// mode (-d) it also logs the dropped/sent counters so operators can see
+// backpressure happening.
+func (s *elasticSink) flush() {
+	if len(s.batch) == 0 {
+		return
+	}
+
+	var body bytes.Buffer
+	for _, doc := range s.batch {
+		body.WriteString(fmt.Sprintf(`{"index":{"_index":%q}}`, elasticIndexName))
+		body.WriteByte('\n')
+		docJSON, err := json.Marshal(doc)
+		if err != nil {
+			log.Println("Error marshalling Elasticsearch document:", err)
+			continue
+		}
+		body.Write(docJSON)
+		body.WriteByte('\n')
+	}
+
+	resp, err := s.client.Post(s.url+"/_bulk", "application/x-ndjson", &body)
+	if err != nil {
+		log.Println("Error forwarding batch to Elasticsearch:", err)
+		s.batch = s.batch[:0]
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Println("Elasticsearch bulk request rejected:", resp.Status)
+	} else {
+		sent := atomic.AddInt64(&s.sent, int64(len(s.batch)))
+		if debugMode {
+			log.Printf("Elasticsearch: sent %d, dropped %d (cumulative)", sent, atomic.LoadInt64(&s.dropped))
+		}
+	}
+
+	s.batch = s.batch[:0]
+}
+
+// ensureIndexTemplate registers an index template on first run so numeric
+// telemetry fields are mapped as float/long in Elasticsearch instead of
+// being dynamically detected as strings.
+func (s *elasticSink) ensureIndexTemplate() error {
+	template := map[string]interface{}{
+		"index_patterns": []string{elasticIndexName + "*"},
+		"template": map[string]interface{}{
+			"mappings": map[string]interface{}{
+				"dynamic_templates": []map[string]interface{}{
+					{
+						"floats": map[string]interface{}{
+							"match_mapping_type": "double",
+							"mapping":            map[string]interface{}{"type": "float"},
+						},
+					},
+					{
+						"integers": map[string]interface{}{
+							"match_mapping_type": "long",
+							"mapping":            map[string]interface{}{"type": "long"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, s.url+"/_index_template/"+elasticIndexName, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status creating index template: %s", resp.Status)
+	}
+	return nil
+}
+
+// toElasticFields flattens the per-type telemetry maps into a single
+// generic map suitable for JSON encoding as one Elasticsearch document.
+func toElasticFields(s32map, u32map map[string]uint32, f32map map[string]float32, u16map map[string]uint16, u8map map[string]uint8, s8map map[string]int8) map[string]interface{} {
+	fields := make(map[string]interface{}, len(s32map)+len(u32map)+len(f32map)+len(u16map)+len(u8map)+len(s8map))
+	for k, v := range s32map {
+		fields[k] = v
+	}
+	for k, v := range u32map {
+		fields[k] = v
+	}
+	for k, v := range f32map {
+		fields[k] = v
+	}
+	for k, v := range u16map {
+		fields[k] = v
+	}
+	for k, v := range u8map {
+		fields[k] = v
+	}
+	for k, v := range s8map {
+		fields[k] = v
+	}
+	return fields
+}
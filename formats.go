@@ -0,0 +1,143 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+)
+
+// knownFormat pairs a packet format file with the game it belongs to. The
+// summed byte size of each file's fields becomes the key used to recognise incoming UDP
+// packets, so the tool can tell FM7's "sled" format apart from FH4/FH5's
+// "dash" formats without any user-supplied flag.
+type knownFormat struct {
+	file string
+	game string
+}
+
+var knownFormatFiles = []knownFormat{
+	{"FM7_packetformat.dat", "FM7"},
+	{"FH4_packetformat.dat", "FH4"},
+	{"FH5_packetformat.dat", "FH5"},
+}
+
+// formatInfo is everything readForzaData needs once a packet's size has
+// matched a known format.
+type formatInfo struct {
+	telemArray []Telemetry
+	game       string
+}
+
+var (
+	unknownSizeWarned   = make(map[int]bool)
+	unknownSizeWarnedMu sync.Mutex
+)
+
+// loadFormats reads every file in knownFormatFiles and returns a map from
+// expected packet size (in bytes) to the format describing that size's
+// layout, so readForzaData can dispatch each datagram to the right parser
+// purely based on its length.
+func loadFormats(files []knownFormat) (map[int]formatInfo, error) {
+	formats := make(map[int]formatInfo)
+
+	for _, kf := range files {
+		telemArray, err := parseFormatFile(kf.file)
+		if err != nil {
+			return nil, err
+		}
+
+		packetSize := 0
+		if len(telemArray) > 0 {
+			packetSize = telemArray[len(telemArray)-1].endOffset
+		}
+
+		log.Printf("Loaded %s: %d Telemetry types, %d byte packets", kf.file, len(telemArray), packetSize)
+		formats[packetSize] = formatInfo{telemArray: telemArray, game: kf.game}
+	}
+
+	return formats, nil
+}
+
+// parseFormatFile processes a single packet format file into an ordered
+// array of Telemetry structs describing each field's byte offsets.
+func parseFormatFile(formatFile string) ([]Telemetry, error) {
+	lines, err := readLines(formatFile)
+	if err != nil {
+		return nil, err
+	}
+
+	startOffset := 0
+	endOffset := 0
+	dataLength := 0
+	var telemArray []Telemetry
+
+	log.Printf("Processing %s...", formatFile)
+	for i, line := range lines {
+		dataClean := strings.Split(line, ";")          // remove comments after ; from data format file
+		dataFormat := strings.Split(dataClean[0], " ") // array containing data type and name
+		dataType := dataFormat[0]
+		dataName := dataFormat[1]
+
+		switch dataType {
+		case "s32": // Signed 32bit int
+			dataLength = 4 // Number of bytes
+		case "u32": // Unsigned 32bit int
+			dataLength = 4
+		case "f32": // Floating point 32bit
+			dataLength = 4
+		case "u16": // Unsigned 16bit int
+			dataLength = 2
+		case "u8": // Unsigned 8bit int
+			dataLength = 1
+		case "s8": // Signed 8bit int
+			dataLength = 1
+		case "hzn": // Forza Horizon unknown values (12 bytes of.. something)
+			dataLength = 12
+		default:
+			log.Fatalf("Error: Unknown data type in %s \n", formatFile)
+		}
+
+		endOffset = endOffset + dataLength
+		startOffset = endOffset - dataLength
+		telemItem := Telemetry{i, dataName, dataType, startOffset, endOffset}
+		telemArray = append(telemArray, telemItem)
+
+		//Debug format file processing:
+		if debugMode {
+			log.Printf("Processed %s line %d: %s (%s),  Byte offset: %d:%d \n", formatFile, i, dataName, dataType, startOffset, endOffset)
+		}
+	}
+
+	if debugMode { // Print completed telemetry array
+		log.Printf("Logging entire telemArray for %s: \n%v", formatFile, telemArray)
+	}
+
+	return telemArray, nil
+}
+
+// widestFormat returns the Telemetry array with the most fields among the
+// loaded formats, used as the canonical CSV column layout since CSV output
+// needs one fixed set of columns regardless of which format a given packet
+// matched.
+func widestFormat(formats map[int]formatInfo) []Telemetry {
+	var widest []Telemetry
+	for _, f := range formats {
+		if len(f.telemArray) > len(widest) {
+			widest = f.telemArray
+		}
+	}
+	return widest
+}
+
+// warnUnknownPacketSize logs a one-time warning the first time a datagram
+// of a given size arrives that doesn't match any loaded format.
+func warnUnknownPacketSize(size int) {
+	unknownSizeWarnedMu.Lock()
+	defer unknownSizeWarnedMu.Unlock()
+
+	if unknownSizeWarned[size] {
+		return
+	}
+	unknownSizeWarned[size] = true
+	log.Printf("Warning: received %d byte packet matching no known format, ignoring", size)
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+// TestParseFormatFileSizes checks that each known packet format file's
+// offsets add up to the exact payload size used to autodetect it in
+// loadFormats (see knownFormatFiles and the FM7/FH4/FH5 .dat files).
+func TestParseFormatFileSizes(t *testing.T) {
+	cases := []struct {
+		file string
+		size int
+	}{
+		{"FM7_packetformat.dat", 232},
+		{"FH4_packetformat.dat", 311},
+		{"FH5_packetformat.dat", 324},
+	}
+
+	for _, c := range cases {
+		telemArray, err := parseFormatFile(c.file)
+		if err != nil {
+			t.Fatalf("parseFormatFile(%s): %v", c.file, err)
+		}
+		if len(telemArray) == 0 {
+			t.Fatalf("parseFormatFile(%s): got no fields", c.file)
+		}
+
+		got := telemArray[len(telemArray)-1].endOffset
+		if got != c.size {
+			t.Errorf("parseFormatFile(%s): total size = %d, want %d", c.file, got, c.size)
+		}
+
+		if telemArray[0].startOffset != 0 {
+			t.Errorf("parseFormatFile(%s): first field startOffset = %d, want 0", c.file, telemArray[0].startOffset)
+		}
+		for i := 1; i < len(telemArray); i++ {
+			if telemArray[i].startOffset != telemArray[i-1].endOffset {
+				t.Errorf("parseFormatFile(%s): field %d startOffset %d does not follow previous endOffset %d",
+					c.file, i, telemArray[i].startOffset, telemArray[i-1].endOffset)
+			}
+		}
+	}
+}
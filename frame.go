@@ -0,0 +1,30 @@
+package main
+
+import "time"
+
+// telemetryFrame is a single fully-parsed UDP packet, timestamped at the
+// moment it was decoded so subscribers can tell frames apart even when
+// several arrive within the same millisecond.
+type telemetryFrame struct {
+	TimestampNs int64              `json:"timestamp_ns"`
+	S32         map[string]uint32  `json:"s32"`
+	U32         map[string]uint32  `json:"u32"`
+	F32         map[string]float32 `json:"f32"`
+	U16         map[string]uint16  `json:"u16"`
+	U8          map[string]uint8   `json:"u8"`
+	S8          map[string]int8    `json:"s8"`
+}
+
+// newTelemetryFrame builds a telemetryFrame from the per-type maps produced
+// while decoding a UDP packet in readForzaData.
+func newTelemetryFrame(s32map, u32map map[string]uint32, f32map map[string]float32, u16map map[string]uint16, u8map map[string]uint8, s8map map[string]int8) telemetryFrame {
+	return telemetryFrame{
+		TimestampNs: time.Now().UnixNano(),
+		S32:         s32map,
+		U32:         u32map,
+		F32:         f32map,
+		U16:         u16map,
+		U8:          u8map,
+		S8:          s8map,
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// upgrader upgrades incoming /ws requests to WebSocket connections. Origin
+// checking is left permissive since this is a local telemetry tool, not a
+// public-facing service.
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// serveJSON starts the HTTP server that exposes the latest parsed telemetry
+// frame as JSON on "/", plus a "/ws" endpoint streaming every frame as it
+// arrives for clients that want push updates instead of polling.
+func serveJSON() {
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, jsonData)
+	})
+	http.HandleFunc("/ws", serveWS)
+	http.HandleFunc("/stats", serveStats)
+
+	log.Println("JSON HTTP server listening on :8080")
+	log.Fatal(http.ListenAndServe(":8080", nil))
+}
+
+// serveStats exposes the lap tracker's current aggregates (current lap,
+// live delta-to-best, last completed lap's summary) so a HUD can show them
+// without recomputing anything from raw telemetry.
+func serveStats(w http.ResponseWriter, r *http.Request) {
+	if activeLapTracker == nil {
+		http.Error(w, "lap tracking not initialised yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(activeLapTracker.Snapshot()); err != nil {
+		log.Println("Error encoding /stats response:", err)
+	}
+}
+
+// serveWS upgrades the connection and streams every telemetry frame
+// published on frameBus until the client disconnects. A client that falls
+// behind stays connected and loses its oldest buffered frames instead.
+func serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("WS upgrade failed:", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := frameBus.Subscribe()
+	defer frameBus.Unsubscribe(sub)
+
+	if debugMode {
+		log.Println("WS client connected:", conn.RemoteAddr())
+	}
+
+	for frame := range sub {
+		if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+			if debugMode {
+				log.Println("WS client disconnected:", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lapSummaryFile is where a one-line JSON summary is appended every time a
+// lap completes, independent of whatever the regular CSV/JSON sinks are doing.
+const lapSummaryFile = "lap_summary.json"
+
+// lapSummary is the aggregate computed for one completed lap.
+type lapSummary struct {
+	LapNumber         int     `json:"lap_number"`
+	MinSpeedMPH       float64 `json:"min_speed_mph"`
+	MaxSpeedMPH       float64 `json:"max_speed_mph"`
+	AvgSpeedMPH       float64 `json:"avg_speed_mph"`
+	MaxLateralG       float64 `json:"max_lateral_g"`
+	MaxCombinedSlip   float64 `json:"max_combined_slip"`
+	OversteerSeconds  float64 `json:"oversteer_seconds"`
+	UndersteerSeconds float64 `json:"understeer_seconds"`
+	NeutralSeconds    float64 `json:"neutral_seconds"`
+	BestLapTime       float32 `json:"best_lap_time"`
+}
+
+// lapTracker watches LapNumber for changes and, on every change, closes the
+// just-finished lap's CSV file, computes its summary stats, and opens a
+// fresh CSV file for the new lap.
+type lapTracker struct {
+	mu sync.Mutex
+
+	csvBase   string // "" if CSV logging disabled
+	csvHeader string
+	csvFile   *os.File
+
+	initialized bool
+	currentLap  int
+
+	lastSampleTime time.Time
+	sampleCount    int
+	speedSum       float64
+	minSpeed       float64
+	maxSpeed       float64
+	maxLateralG    float64
+	maxSlip        float64
+	oversteerDur   time.Duration
+	understeerDur  time.Duration
+	neutralDur     time.Duration
+
+	lastSummary    *lapSummary
+	currentLapTime float32
+	bestLapTime    float32
+}
+
+// newLapTracker creates a tracker. csvFile is the base path passed via -c;
+// pass "" to disable per-lap CSV output. csvHeader is the column header line
+// (without trailing newline) written to each new lap's CSV file.
+func newLapTracker(csvFile string, csvHeader string) *lapTracker {
+	return &lapTracker{csvBase: csvFile, csvHeader: csvHeader}
+}
+
+// Update feeds one parsed sample into the tracker. speedMPH, lateralG and
+// combinedSlip are derived from the current packet's telemetry; attitude is
+// CheckAttitude's verdict for this sample; currentLapTime/bestLapTime come
+// straight from the telemetry's CurrentLap/BestLap fields. csvLine, if
+// non-empty, is appended (with a trailing newline) to the current lap's CSV
+// file.
+func (t *lapTracker) Update(lapNumber int, speedMPH float64, lateralG float64, combinedSlip float64, attitude string, currentLapTime float32, bestLapTime float32, csvLine string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.currentLapTime = currentLapTime
+	t.bestLapTime = bestLapTime
+
+	if !t.initialized {
+		t.initialized = true
+		t.currentLap = lapNumber
+		t.startLapLocked(lapNumber)
+		t.lastSampleTime = now
+	} else if lapNumber != t.currentLap {
+		t.finishLapLocked(bestLapTime)
+		t.currentLap = lapNumber
+		t.startLapLocked(lapNumber)
+		t.lastSampleTime = now
+	}
+
+	elapsed := now.Sub(t.lastSampleTime)
+	t.lastSampleTime = now
+
+	switch attitude {
+	case "Oversteer":
+		t.oversteerDur += elapsed
+	case "Understeer":
+		t.understeerDur += elapsed
+	default:
+		t.neutralDur += elapsed
+	}
+
+	if t.sampleCount == 0 || speedMPH < t.minSpeed {
+		t.minSpeed = speedMPH
+	}
+	if speedMPH > t.maxSpeed {
+		t.maxSpeed = speedMPH
+	}
+	t.speedSum += speedMPH
+	t.sampleCount++
+
+	if lateralG > t.maxLateralG {
+		t.maxLateralG = lateralG
+	}
+	if combinedSlip > t.maxSlip {
+		t.maxSlip = combinedSlip
+	}
+
+	if t.csvFile != nil && csvLine != "" {
+		if _, err := fmt.Fprintln(t.csvFile, csvLine); err != nil {
+			log.Println("Error writing lap CSV row:", err)
+		}
+	}
+}
+
+// startLapLocked opens a fresh CSV file for lapNumber and writes its header.
+// Caller must hold t.mu.
+func (t *lapTracker) startLapLocked(lapNumber int) {
+	t.sampleCount = 0
+	t.speedSum = 0
+	t.minSpeed = 0
+	t.maxSpeed = 0
+	t.maxLateralG = 0
+	t.maxSlip = 0
+	t.oversteerDur = 0
+	t.understeerDur = 0
+	t.neutralDur = 0
+
+	if t.csvBase == "" {
+		return
+	}
+
+	path := lapCSVPath(t.csvBase, lapNumber)
+	file, err := os.Create(path)
+	if err != nil {
+		log.Println("Error creating lap CSV file:", err)
+		return
+	}
+	if _, err := fmt.Fprintln(file, t.csvHeader); err != nil {
+		log.Println("Error writing lap CSV header:", err)
+	}
+
+	t.csvFile = file
+	log.Println("Logging lap", lapNumber, "to", path)
+}
+
+// finishLapLocked closes the current lap's CSV file, computes its summary
+// and appends it as one JSON line to lapSummaryFile. Caller must hold t.mu.
+func (t *lapTracker) finishLapLocked(bestLapTime float32) {
+	if t.csvFile != nil {
+		t.csvFile.Close()
+		t.csvFile = nil
+	}
+
+	avgSpeed := 0.0
+	if t.sampleCount > 0 {
+		avgSpeed = t.speedSum / float64(t.sampleCount)
+	}
+
+	summary := &lapSummary{
+		LapNumber:         t.currentLap,
+		MinSpeedMPH:       t.minSpeed,
+		MaxSpeedMPH:       t.maxSpeed,
+		AvgSpeedMPH:       avgSpeed,
+		MaxLateralG:       t.maxLateralG,
+		MaxCombinedSlip:   t.maxSlip,
+		OversteerSeconds:  t.oversteerDur.Seconds(),
+		UndersteerSeconds: t.understeerDur.Seconds(),
+		NeutralSeconds:    t.neutralDur.Seconds(),
+		BestLapTime:       bestLapTime,
+	}
+	t.lastSummary = summary
+
+	appendLapSummary(summary)
+}
+
+// appendLapSummary appends one JSON-encoded lapSummary as a line to
+// lapSummaryFile.
+func appendLapSummary(summary *lapSummary) {
+	line, err := json.Marshal(summary)
+	if err != nil {
+		log.Println("Error marshalling lap summary:", err)
+		return
+	}
+
+	file, err := os.OpenFile(lapSummaryFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Println("Error opening lap summary file:", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Println("Error writing lap summary:", err)
+	}
+}
+
+// lapCSVPath derives a per-lap CSV filename from the base path passed via
+// -c, e.g. "session.csv" -> "session_lap3.csv".
+func lapCSVPath(base string, lapNumber int) string {
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	return fmt.Sprintf("%s_lap%d%s", stem, lapNumber, ext)
+}
+
+// Snapshot returns the most recently completed lap's summary plus the
+// current lap number, for the /stats HTTP endpoint. The "last_lap" key is
+// omitted if no lap has completed yet.
+func (t *lapTracker) Snapshot() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshot := map[string]interface{}{
+		"current_lap":      t.currentLap,
+		"current_lap_time": t.currentLapTime,
+		"best_lap_time":    t.bestLapTime,
+	}
+	if t.bestLapTime > 0 {
+		snapshot["delta_to_best_seconds"] = t.currentLapTime - t.bestLapTime
+	}
+	if t.lastSummary != nil {
+		snapshot["last_lap"] = t.lastSummary
+	}
+	return snapshot
+}
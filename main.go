@@ -6,14 +6,12 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"math"
 	"net"
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"syscall"
 )
 
@@ -24,6 +22,10 @@ import (
 var jsonData string // Stores the JSON data to be sent out via the web server if enabled
 var debugMode bool
 
+var frameBus = newFrameBroker() // Fans out every parsed telemetry frame to TCP/WS subscribers
+
+var activeLapTracker *lapTracker // Set once main() constructs it; read by the /stats HTTP endpoint
+
 //var localIP = "0.0.0.0"
 //var localUDP = "13139"
 
@@ -36,8 +38,10 @@ type Telemetry struct {
 	endOffset   int
 }
 
-// readForzaData processes recieved UDP packets
-func readForzaData(conn *net.UDPConn, telemArray []Telemetry, csvFile string, jsonFile string) {
+// readForzaData reads one UDP packet, optionally records it, and hands it
+// off to processPacket. Replay mode skips this entirely and calls
+// processPacket directly against a recorded capture (see replay.go).
+func readForzaData(conn *net.UDPConn, formats map[int]formatInfo, csvFile string, jsonFile string, elastic *elasticSink, laps *lapTracker, metrics *telemetryMetrics, recorder *packetRecorder) {
 	buffer := make([]byte, 1500)
 
 	n, addr, err := conn.ReadFromUDP(buffer)
@@ -50,10 +54,38 @@ func readForzaData(conn *net.UDPConn, telemArray []Telemetry, csvFile string, js
 		// fmt.Printf("Raw Data from UDP client:\n%s", string(buffer[:n])) // Debug: Dump entire received buffer
 	}
 
-	// TODO: Check length of received packet:
-	// 324 = FH4
-	// use this to switch formats?
-	// fmt.Println(len(string(buffer[:n])))
+	if recorder != nil {
+		if err := recorder.Record(buffer[:n]); err != nil {
+			log.Println("Error recording packet:", err)
+		}
+	}
+
+	processPacket(buffer[:n], formats, csvFile, jsonFile, elastic, laps, metrics)
+}
+
+// processPacket parses a single raw Forza UDP payload and feeds it through
+// every enabled sink (terminal, CSV, JSON server, Elasticsearch). It is
+// shared between the live UDP path and replay playback so recorded captures
+// behave identically to the original session.
+func processPacket(payload []byte, formats map[int]formatInfo, csvFile string, jsonFile string, elastic *elasticSink, laps *lapTracker, metrics *telemetryMetrics) {
+	n := len(payload)
+
+	// Packet length distinguishes FM7's "sled" format from FH4/FH5's "dash"
+	// formats (232 vs 311 vs 324 bytes), so dispatch to the matching parser
+	// loaded at startup instead of relying on a fixed, user-selected format.
+	format, ok := formats[n]
+	if !ok {
+		warnUnknownPacketSize(n)
+		if metrics != nil {
+			metrics.parseErrors.Inc()
+		}
+		return
+	}
+	telemArray := format.telemArray
+
+	if metrics != nil {
+		metrics.packetsReceived.Inc()
+	}
 
 	// Create some maps to store the latest values for each data type
 	s32map := make(map[string]uint32)
@@ -65,7 +97,7 @@ func readForzaData(conn *net.UDPConn, telemArray []Telemetry, csvFile string, js
 
 	// Use Telemetry array to map raw data against Forza's data format
 	for i, T := range telemArray {
-		data := buffer[:n][T.startOffset:T.endOffset] // Process received data in chunks based on byte offsets
+		data := payload[T.startOffset:T.endOffset] // Process received data in chunks based on byte offsets
 
 		if debugMode { // if debugMode, print received data in each chunk
 			log.Printf("Data chunk %d: %v (%s) (%s)", i, data, T.name, T.dataType)
@@ -96,16 +128,40 @@ func readForzaData(conn *net.UDPConn, telemArray []Telemetry, csvFile string, js
 	// This happens if the game is paused or you rewind
 	// There is a bug with FH4 where it will continue to send data when in certain menus
 	if f32map["CurrentEngineRpm"] == 0 {
+		if metrics != nil {
+			metrics.packetsDroppedZeroRpm.Inc()
+		}
 		return
 	}
 
+	// Update Prometheus gauges if enabled. This happens at 60Hz under the
+	// gauges' own internal locking; Grafana can scrape at whatever rate it
+	// likes without needing a bespoke sink.
+	if metrics != nil {
+		metrics.Observe(s32map, u32map, f32map, u16map, u8map, s8map)
+	}
+
+	// Publish the fully-parsed frame to any TCP/WS subscribers. This runs
+	// unconditionally so push-based consumers don't depend on the -s/-c flags.
+	frameJSON, err := json.Marshal(newTelemetryFrame(s32map, u32map, f32map, u16map, u8map, s8map))
+	if err != nil {
+		log.Println("Error marshalling telemetry frame:", err)
+	} else {
+		frameBus.Publish(frameJSON)
+	}
+
+	// Forward data to Elasticsearch if enabled:
+	if elastic != nil {
+		elastic.Submit(format.game, toElasticFields(s32map, u32map, f32map, u16map, u8map, s8map))
+	}
+
+	// Convert slip values to ints as the precision of a float means a neutral state is rarely reported
+	totalSlipRear := int(f32map["TireCombinedSlipRearLeft"] + f32map["TireCombinedSlipRearRight"])
+	totalSlipFront := int(f32map["TireCombinedSlipFrontLeft"] + f32map["TireCombinedSlipFrontRight"])
+	carAttitude := CheckAttitude(totalSlipFront, totalSlipRear)
+
 	// Print received data to terminal (if not in quiet mode):
 	if isFlagPassed("q") {
-		// Convert slip values to ints as the precision of a float means a neutral state is rarely reported
-		totalSlipRear := int(f32map["TireCombinedSlipRearLeft"] + f32map["TireCombinedSlipRearRight"])
-		totalSlipFront := int(f32map["TireCombinedSlipFrontLeft"] + f32map["TireCombinedSlipFrontRight"])
-		carAttitude := CheckAttitude(totalSlipFront, totalSlipRear)
-
 		log.Printf("RPM: %.0f \t Gear: %d \t BHP: %.0f \t Speed: %.0f \t Total slip: %.0f \t Attitude: %s", f32map["CurrentEngineRpm"], u8map["Gear"], (f32map["Power"] / 745.7), (f32map["Speed"] * 2.237), (f32map["TireCombinedSlipRearLeft"] + f32map["TireCombinedSlipRearRight"]), carAttitude)
 		// "Traction control" if slip higher than threshold and not understeering
 		if (totalSlipRear+totalSlipFront) > 2 && carAttitude == "Oversteer" { // Basic traction control detection testing where we allow slip up to a certain amount
@@ -113,15 +169,14 @@ func readForzaData(conn *net.UDPConn, telemArray []Telemetry, csvFile string, js
 		}
 	}
 
-	// Write data to CSV file if enabled:
-	if isFlagPassed("c") {
-		file, err := os.OpenFile(csvFile, os.O_WRONLY|os.O_APPEND, 0644)
-		check(err)
-		defer file.Close()
-
+	// Build the CSV row (used below by the lap tracker) and feed the lap
+	// tracker with this sample's derived stats. Lap segmentation is driven by
+	// LapNumber; per-lap CSV files are only written when laps.csvBase is
+	// non-empty (i.e. -c was passed), while lap_summary.json is appended to
+	// on every lap change either way.
+	if laps != nil {
 		csvLine := ""
-
-		for _, T := range telemArray { // Construct CSV line
+		for _, T := range widestFormat(formats) { // Construct CSV line using the widest known format's column order
 			switch T.dataType {
 			case "s32":
 				csvLine += "," + fmt.Sprint(s32map[T.name])
@@ -139,9 +194,13 @@ func readForzaData(conn *net.UDPConn, telemArray []Telemetry, csvFile string, js
 				csvLine += ","
 			}
 		}
-		csvLine += "\n"
-		fmt.Fprintf(file, csvLine[1:]) // write new line to file
-	} // end of if CSV enabled
+
+		speedMPH := float64(f32map["Speed"] * 2.237)
+		lateralG := math.Abs(float64(f32map["AccelerationX"])) / 9.81
+		combinedSlip := math.Max(math.Abs(float64(totalSlipFront)), math.Abs(float64(totalSlipRear)))
+
+		laps.Update(int(u16map["LapNumber"]), speedMPH, lateralG, combinedSlip, carAttitude, f32map["CurrentLap"], f32map["BestLap"], csvLine[1:])
+	}
 
 	// Send data to JSON server if enabled:
 	if isFlagPassed("s") {
@@ -188,7 +247,6 @@ func readForzaData(conn *net.UDPConn, telemArray []Telemetry, csvFile string, js
 
 func main() {
 	var csvFile string
-	var horizonMode bool
 	var jsonEnabled bool
 	var jsonFile string
 	var noTerm bool
@@ -198,19 +256,28 @@ func main() {
 	var elastic bool
 	var elasticIP string
 	var elasticPort int
+	var tcpPort int
+	var recordFile string
+	var playFile string
+	var playSpeed float64
+	var metricsPort int
 
 	// Parse flags
 	flag.StringVar(&csvFile, "c", "", "Log data to given file in CSV format")
-	flag.BoolVar(&horizonMode, "z", false, "Enables Forza Horizon 4 support (Will default to Forza Motorsport if unset)")
 	flag.BoolVar(&jsonEnabled, "s", false, "Enables JSON HTTP server on port 8080")
 	flag.StringVar(&jsonFile, "j", "", "Log data to given file in JSON format")
 	flag.BoolVar(&noTerm, "q", true, "Disables realtime terminal output if set")
 	flag.BoolVar(&debugMode, "d", false, "Enables extra debug information if set")
 	flag.IntVar(&localUDP, "u", 9999, "UDP port to use. For future use!")
 	flag.StringVar(&localIP, "i", "0.0.0.0", "IP local Address to bind to. For future use!")
-	flag.BoolVar(&elastic, "e", false, "Forward to elastic. For future use!")
-	flag.StringVar(&elasticIP, "y", "127.0.0.1", "IP Address for elastic. For future use!")
-	flag.IntVar(&elasticPort, "p", 5600, "port for elastic stack. For future use!")
+	flag.BoolVar(&elastic, "e", false, "Forward telemetry to Elasticsearch using the _bulk API")
+	flag.StringVar(&elasticIP, "y", "127.0.0.1", "IP Address for Elasticsearch")
+	flag.IntVar(&elasticPort, "p", 5600, "port for Elasticsearch")
+	flag.IntVar(&tcpPort, "t", 0, "Enables TCP line-delimited JSON stream on given port (0 disables)")
+	flag.StringVar(&recordFile, "r", "", "Record raw UDP packets to given file for later playback")
+	flag.StringVar(&playFile, "P", "", "Play back a file previously recorded with -r instead of listening on UDP")
+	flag.Float64Var(&playSpeed, "speed", 1, "Playback speed multiplier, only used with -P")
+	flag.IntVar(&metricsPort, "m", 0, "Enables a Prometheus /metrics endpoint on given port (0 disables)")
 	flag.Parse()
 
 	socketParms := localIP + ":" + strconv.Itoa(localUDP)
@@ -218,7 +285,7 @@ func main() {
 	log.Printf("Debug PGB_02 elastic     : %t, %s, %d.", elastic, elasticIP, elasticPort)
 	log.Printf("Debug PGB_03 json        : %t, %s.", jsonEnabled, jsonFile)
 	log.Printf("Debug PGB_04 cvs         : %s.", csvFile)
-	log.Printf("Debug PGB_05 d, q, z     : %t, %t, %t.", debugMode, noTerm, horizonMode)
+	log.Printf("Debug PGB_05 d, q        : %t, %t.", debugMode, noTerm)
 	//os.Exit(0)
 
 	SetupCloseHandler(csvFile, jsonFile) // handle CTRL+C
@@ -231,103 +298,21 @@ func main() {
 		log.Println("Realtime terminal data output disabled")
 	}
 
-	// Switch to Horizon format if needed
-	var formatFile = "FM7_packetformat.dat" // Path to file containing Forzas data format
-	if horizonMode {
-		formatFile = "FH4_packetformat.dat"
-		log.Println("Forza Horizon mode selected")
-	} else {
-		log.Println("Forza Motorsport mode selected")
-	}
-
-	// Load lines from packet format file
-	lines, err := readLines(formatFile)
+	// Load every known packet format up front and key them by packet size, so
+	// incoming UDP datagrams are dispatched to the right parser at runtime
+	// instead of requiring the user to pick FM7 vs Horizon ahead of time.
+	formats, err := loadFormats(knownFormatFiles)
 	if err != nil {
 		log.Fatalf("Error reading format file: %s", err)
 	}
 
-	// Process format file into array of Telemetry structs
-	startOffset := 0
-	endOffset := 0
-	dataLength := 0
-	var telemArray []Telemetry
-
-	log.Printf("Processing %s...", formatFile)
-	for i, line := range lines {
-		dataClean := strings.Split(line, ";")          // remove comments after ; from data format file
-		dataFormat := strings.Split(dataClean[0], " ") // array containing data type and name
-		dataType := dataFormat[0]
-		dataName := dataFormat[1]
-
-		switch dataType {
-		case "s32": // Signed 32bit int
-			dataLength = 4 // Number of bytes
-			endOffset = endOffset + dataLength
-			startOffset = endOffset - dataLength
-			telemItem := Telemetry{i, dataName, dataType, startOffset, endOffset} // Create new Telemetry item / data point
-			telemArray = append(telemArray, telemItem)                            // Add Telemetry item to main telemetry array
-		case "u32": // Unsigned 32bit int
-			dataLength = 4
-			endOffset = endOffset + dataLength
-			startOffset = endOffset - dataLength
-			telemItem := Telemetry{i, dataName, dataType, startOffset, endOffset}
-			telemArray = append(telemArray, telemItem)
-		case "f32": // Floating point 32bit
-			dataLength = 4
-			endOffset = endOffset + dataLength
-			startOffset = endOffset - dataLength
-			telemItem := Telemetry{i, dataName, dataType, startOffset, endOffset}
-			telemArray = append(telemArray, telemItem)
-		case "u16": // Unsigned 16bit int
-			dataLength = 2
-			endOffset = endOffset + dataLength
-			startOffset = endOffset - dataLength
-			telemItem := Telemetry{i, dataName, dataType, startOffset, endOffset}
-			telemArray = append(telemArray, telemItem)
-		case "u8": // Unsigned 8bit int
-			dataLength = 1
-			endOffset = endOffset + dataLength
-			startOffset = endOffset - dataLength
-			telemItem := Telemetry{i, dataName, dataType, startOffset, endOffset}
-			telemArray = append(telemArray, telemItem)
-		case "s8": // Signed 8bit int
-			dataLength = 1
-			endOffset = endOffset + dataLength
-			startOffset = endOffset - dataLength
-			telemItem := Telemetry{i, dataName, dataType, startOffset, endOffset}
-			telemArray = append(telemArray, telemItem)
-		case "hzn": // Forza Horizon 4 unknown values (12 bytes of.. something)
-			dataLength = 12
-			endOffset = endOffset + dataLength
-			startOffset = endOffset - dataLength
-			telemItem := Telemetry{i, dataName, dataType, startOffset, endOffset}
-			telemArray = append(telemArray, telemItem)
-		default:
-			log.Fatalf("Error: Unknown data type in %s \n", formatFile)
-		}
-		//Debug format file processing:
-		if debugMode {
-			log.Printf("Processed %s line %d: %s (%s),  Byte offset: %d:%d \n", formatFile, i, dataName, dataType, startOffset, endOffset)
-		}
-	}
-
-	if debugMode { // Print completed telemetry array
-		log.Printf("Logging entire telemArray: \n%v", telemArray)
-	}
-
-	log.Printf("Proccessed %d Telemetry types OK!", len(telemArray))
-
-	// Prepare CSV file if requested
+	// CSV logging is now per-lap (see lapTracker below): each lap gets its
+	// own file, named from csvFile, created on lap boundaries. Since the CSV
+	// format assumes one fixed set of columns, the header is taken from the
+	// richest known format (currently FH5's "dash" layout); packets matching
+	// a smaller format write 0 in the columns their format lacks for that row.
 	if isFlagPassed("c") {
-		log.Println("Logging data to", csvFile)
-
-		csvHeader := ""
-		for _, T := range telemArray { // Construct CSV header/column names
-			csvHeader += "," + T.name
-		}
-		csvHeader = csvHeader + "\n"
-		err := ioutil.WriteFile(csvFile, []byte(csvHeader)[1:], 0644)
-		check(err)
+		log.Println("Logging data to per-lap files based on", csvFile)
 	} else {
 		log.Println("CSV Logging disabled")
 	}
@@ -351,6 +336,63 @@ func main() {
 		go serveJSON()
 	}
 
+	// Start TCP streaming server if requested
+	if isFlagPassed("t") {
+		go serveTCP(tcpPort)
+	}
+
+	// Set up Elasticsearch forwarding if requested
+	var esink *elasticSink
+	if elastic {
+		esink = newElasticSink(elasticIP, elasticPort)
+		log.Printf("Forwarding telemetry to Elasticsearch at %s:%d", elasticIP, elasticPort)
+	}
+
+	// Set up lap tracking. Per-lap CSV files are only written when -c was
+	// passed; lap summaries are always written, and the /stats endpoint is
+	// served alongside the JSON server when -s is passed.
+	lapCSVBase := ""
+	if isFlagPassed("c") {
+		lapCSVBase = csvFile
+	}
+	csvHeader := ""
+	for _, T := range widestFormat(formats) {
+		csvHeader += "," + T.name
+	}
+	if len(csvHeader) > 0 {
+		csvHeader = csvHeader[1:]
+	}
+	laps := newLapTracker(lapCSVBase, csvHeader)
+	activeLapTracker = laps
+
+	// Start Prometheus metrics endpoint if requested
+	var metrics *telemetryMetrics
+	if isFlagPassed("m") {
+		metrics = newTelemetryMetrics()
+		go serveMetrics(metricsPort)
+	}
+
+	// Replay mode: feed a previously recorded capture straight into
+	// processPacket at its original inter-arrival timing, bypassing the UDP
+	// listener entirely so every sink behaves exactly as it did live.
+	if isFlagPassed("P") {
+		if err := playRecording(playFile, playSpeed, formats, csvFile, jsonFile, esink, laps, metrics); err != nil {
+			log.Fatalf("Error playing back %s: %s", playFile, err)
+		}
+		return
+	}
+
+	// Set up raw packet recording if requested
+	var recorder *packetRecorder
+	if isFlagPassed("r") {
+		recorder, err = newPacketRecorder(recordFile)
+		if err != nil {
+			log.Fatalf("Error creating recording %s: %s", recordFile, err)
+		}
+		defer recorder.Close()
+		log.Println("Recording raw UDP packets to", recordFile)
+	}
+
 	// Setup UDP listener
 	udpAddr, err := net.ResolveUDPAddr("udp4", socketParms)
 	if err != nil {
@@ -365,7 +407,7 @@ func main() {
 	log.Printf("Forza data out server listening on %s:%d, waiting for Forza data...\n", GetOutboundIP(), localUDP)
 
 	for { // main loop
-		readForzaData(listener, telemArray, csvFile, jsonFile) // Also pass telemArray to UDP function - might be a better way instea do of passing each time?
+		readForzaData(listener, formats, csvFile, jsonFile, esink, laps, metrics, recorder) // Also pass formats to UDP function - might be a better way instea do of passing each time?
 	}
 }
 
@@ -390,6 +432,42 @@ func SetupCloseHandler(csvFile string, jsonFile string) {
 	}()
 }
 
+// calcstats logs a short aggregate of the recorded laps by reading back
+// lapSummaryFile, which the lapTracker appends one JSON line to every time a
+// lap completes. That file is never truncated, so laps appended by earlier
+// runs in the same directory are counted too. csvFile is not read here; the
+// caller only invokes calcstats when CSV logging was enabled (the per-lap
+// files themselves are named from it; see lapCSVPath).
+func calcstats(csvFile string) {
+	file, err := os.Open(lapSummaryFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Error reading lap summary for stats:", err)
+		}
+		return
+	}
+	defer file.Close()
+
+	var lapCount int
+	var bestLapTime float32
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var summary lapSummary
+		if err := json.Unmarshal(scanner.Bytes(), &summary); err != nil {
+			continue
+		}
+		lapCount++
+		if summary.BestLapTime > 0 && (bestLapTime == 0 || summary.BestLapTime < bestLapTime) {
+			bestLapTime = summary.BestLapTime
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Println("Error reading lap summary for stats:", err)
+	}
+
+	log.Printf("Session stats: %d lap(s) recorded, best lap time %.3fs", lapCount, bestLapTime)
+}
+
 // Quick error check helper
 func check(e error) {
 	if e != nil {
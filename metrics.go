@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// telemetryMetrics exposes every numeric telemetry field as a Prometheus
+// gauge (e.g. forza_current_engine_rpm), plus a handful of counters tracking
+// the health of the UDP intake itself. Gauges are created lazily the first
+// time a given field name is seen, since the field set depends on which
+// packet format matched.
+type telemetryMetrics struct {
+	mu     sync.Mutex
+	gauges map[string]prometheus.Gauge
+
+	packetsReceived       prometheus.Counter
+	packetsDroppedZeroRpm prometheus.Counter
+	parseErrors           prometheus.Counter
+}
+
+// newTelemetryMetrics registers the fixed counters and returns a tracker
+// ready to have per-field gauges added to it as telemetry arrives.
+func newTelemetryMetrics() *telemetryMetrics {
+	return &telemetryMetrics{
+		gauges: make(map[string]prometheus.Gauge),
+		packetsReceived: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "forza_packets_received_total",
+			Help: "Total number of Forza UDP packets matched to a known format.",
+		}),
+		packetsDroppedZeroRpm: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "forza_packets_dropped_zero_rpm_total",
+			Help: "Total number of packets dropped because CurrentEngineRpm was zero (paused/rewinding).",
+		}),
+		parseErrors: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "forza_parse_errors_total",
+			Help: "Total number of UDP packets that didn't match any known packet format.",
+		}),
+	}
+}
+
+// gaugeFor returns the gauge for the given telemetry field name, registering
+// it with Prometheus the first time it's seen.
+func (m *telemetryMetrics) gaugeFor(name string) prometheus.Gauge {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if g, ok := m.gauges[name]; ok {
+		return g
+	}
+
+	g := promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "forza_" + toSnakeCase(name),
+		Help: "Forza telemetry field " + name + ".",
+	})
+	m.gauges[name] = g
+	return g
+}
+
+// Observe updates every field's gauge from the latest parsed packet. Caller
+// should hold no lock; gaugeFor takes care of its own synchronisation.
+func (m *telemetryMetrics) Observe(s32map, u32map map[string]uint32, f32map map[string]float32, u16map map[string]uint16, u8map map[string]uint8, s8map map[string]int8) {
+	for name, v := range s32map {
+		m.gaugeFor(name).Set(float64(v))
+	}
+	for name, v := range u32map {
+		m.gaugeFor(name).Set(float64(v))
+	}
+	for name, v := range f32map {
+		m.gaugeFor(name).Set(float64(v))
+	}
+	for name, v := range u16map {
+		m.gaugeFor(name).Set(float64(v))
+	}
+	for name, v := range u8map {
+		m.gaugeFor(name).Set(float64(v))
+	}
+	for name, v := range s8map {
+		m.gaugeFor(name).Set(float64(v))
+	}
+}
+
+var snakeCaseBoundary = regexp.MustCompile("([a-z0-9])([A-Z])")
+
+// toSnakeCase converts a CamelCase telemetry field name (e.g.
+// "CurrentEngineRpm") into a Prometheus-friendly snake_case metric suffix
+// (e.g. "current_engine_rpm").
+func toSnakeCase(name string) string {
+	snake := snakeCaseBoundary.ReplaceAllString(name, "${1}_${2}")
+	return strings.ToLower(snake)
+}
+
+// serveMetrics starts the promhttp handler on the given port so Grafana (or
+// any Prometheus-compatible scraper) can pull telemetry at whatever interval
+// it likes, independent of the 60Hz UDP arrival rate.
+func serveMetrics(port int) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Printf("Prometheus metrics listening on :%d/metrics", port)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), mux))
+}
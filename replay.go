@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// recorderFlushInterval is how often the recorder's buffered writer is
+// flushed to disk, so a capture survives a crash with at most this much loss.
+const recorderFlushInterval = 1 * time.Second
+
+// packetRecorder writes received UDP datagrams to a length-prefixed binary
+// log: uint32 payload length + uint64 nanosecond timestamp + payload. The
+// log can later be fed back into the pipeline with playRecording.
+type packetRecorder struct {
+	file   *os.File
+	writer *bufio.Writer
+	done   chan struct{}
+}
+
+// newPacketRecorder creates (or truncates) path and starts the periodic
+// flush goroutine that keeps the capture durable without flushing on every
+// single packet.
+func newPacketRecorder(path string) (*packetRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &packetRecorder{
+		file:   file,
+		writer: bufio.NewWriter(file),
+		done:   make(chan struct{}),
+	}
+
+	go r.flushPeriodically()
+	return r, nil
+}
+
+func (r *packetRecorder) flushPeriodically() {
+	ticker := time.NewTicker(recorderFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.writer.Flush(); err != nil {
+				log.Println("Error flushing recording:", err)
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Record appends one datagram to the log as uint32 length + uint64 ns
+// timestamp + payload, all little-endian.
+func (r *packetRecorder) Record(payload []byte) error {
+	if err := binary.Write(r.writer, binary.LittleEndian, uint32(len(payload))); err != nil {
+		return err
+	}
+	if err := binary.Write(r.writer, binary.LittleEndian, uint64(time.Now().UnixNano())); err != nil {
+		return err
+	}
+	_, err := r.writer.Write(payload)
+	return err
+}
+
+// Close flushes any buffered data and closes the underlying file.
+func (r *packetRecorder) Close() error {
+	close(r.done)
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// playRecording reads a capture written by packetRecorder and feeds each
+// packet into processPacket at its original inter-arrival timing (scaled by
+// speed), so every downstream sink sees the same data it would have live.
+func playRecording(path string, speed float64, formats map[int]formatInfo, csvFile string, jsonFile string, elastic *elasticSink, laps *lapTracker, metrics *telemetryMetrics) error {
+	if speed <= 0 {
+		speed = 1
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var lastTimestampNs int64
+	count := 0
+
+	for {
+		var length uint32
+		if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		var timestampNs uint64
+		if err := binary.Read(reader, binary.LittleEndian, &timestampNs); err != nil {
+			return err
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return err
+		}
+
+		if lastTimestampNs != 0 {
+			gap := time.Duration(int64(timestampNs)-lastTimestampNs) * time.Nanosecond
+			time.Sleep(time.Duration(float64(gap) / speed))
+		}
+		lastTimestampNs = int64(timestampNs)
+
+		processPacket(payload, formats, csvFile, jsonFile, elastic, laps, metrics)
+		count++
+	}
+
+	log.Printf("Replay of %s finished: %d packets played back at %.2fx speed", path, count, speed)
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+)
+
+// serveTCP listens on the given port and streams one JSON frame per line to
+// every connected client, so dashboards and overlays can subscribe to
+// telemetry without polling the HTTP JSON server.
+func serveTCP(port int) {
+	addr := fmt.Sprintf(":%d", port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal("Error starting TCP stream server:", err)
+	}
+	defer listener.Close()
+
+	log.Println("TCP line-delimited JSON stream listening on", addr)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Println("TCP accept error:", err)
+			continue
+		}
+		go handleTCPClient(conn)
+	}
+}
+
+// handleTCPClient pushes every frame published on frameBus to conn, one
+// frame per line, until the client disconnects. A client that falls behind
+// stays connected and loses its oldest buffered frames instead.
+func handleTCPClient(conn net.Conn) {
+	defer conn.Close()
+
+	sub := frameBus.Subscribe()
+	defer frameBus.Unsubscribe(sub)
+
+	if debugMode {
+		log.Println("TCP client connected:", conn.RemoteAddr())
+	}
+
+	for frame := range sub {
+		// frame is shared with every other subscriber (it's the same []byte
+		// handed out by frameBus.Publish), so appending to it here would risk
+		// writing into its backing array while another client is reading it.
+		// Write the newline separately instead of mutating the shared slice.
+		if _, err := conn.Write(frame); err != nil {
+			if debugMode {
+				log.Println("TCP client disconnected:", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if _, err := conn.Write([]byte{'\n'}); err != nil {
+			if debugMode {
+				log.Println("TCP client disconnected:", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}